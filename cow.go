@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cowUpperDir makes d.fs.shadowDir an immutable lower layer: every
+// mutation is redirected into this upper directory instead, similar to
+// the overlay/union approach used by go-fuse's newunionfs. Reads fall
+// through to the upper copy when one exists, otherwise to the lower one.
+var cowUpperDir = flag.String("cow", "", "copy-on-write upper directory; shadowDir becomes a read-only lower layer")
+
+func cowEnabled() bool {
+	return *cowUpperDir != ""
+}
+
+// whiteoutPrefix marks that a lower-layer entry has been deleted. It
+// lives next to the real entry in the upper directory, mirroring the
+// ".wh." convention used by OverlayFS.
+const whiteoutPrefix = ".wh."
+
+// upperPath maps a lower (shadow) path to its counterpart in the upper
+// directory, the same string-prefix technique Dir.Symlink already uses
+// to re-root paths between mountDir and shadowDir.
+func upperPath(lowerPath string, fs *ClueFS) string {
+	return strings.Replace(lowerPath, fs.shadowDir, *cowUpperDir, 1)
+}
+
+func whiteoutPath(lowerPath string, fs *ClueFS) string {
+	dir, name := filepath.Split(upperPath(lowerPath, fs))
+	return filepath.Join(dir, whiteoutPrefix+name)
+}
+
+func isWhiteout(upperDirPath, name string) bool {
+	_, err := os.Lstat(filepath.Join(upperDirPath, whiteoutPrefix+name))
+	return err == nil
+}
+
+func upperExists(lowerPath string, fs *ClueFS) bool {
+	_, err := os.Lstat(upperPath(lowerPath, fs))
+	return err == nil
+}
+
+// cowEffectivePath returns the path that Lookup/Lstat/Open should use
+// for lowerPath when --cow is on: the upper copy if one exists, the
+// lower (shadow) copy otherwise, or os.ErrNotExist if the entry only
+// exists behind a whiteout (i.e. it was removed through the mount).
+func cowEffectivePath(lowerPath string, fs *ClueFS) (string, error) {
+	upper := upperPath(lowerPath, fs)
+	if _, err := os.Lstat(upper); err == nil {
+		return upper, nil
+	}
+	dir, name := filepath.Split(upper)
+	if _, err := os.Lstat(filepath.Join(dir, whiteoutPrefix+name)); err == nil {
+		return "", os.ErrNotExist
+	}
+	return lowerPath, nil
+}
+
+// copyUp copies lowerPath into the upper layer on first write, preserving
+// mode, and clears any whiteout that used to hide it. It is a no-op if the
+// upper copy already exists; if lowerPath does not exist in the lower
+// layer, nothing is copied and the upper path is returned.
+func copyUp(lowerPath string, fs *ClueFS) (string, error) {
+	upper := upperPath(lowerPath, fs)
+	if _, err := os.Lstat(upper); err == nil {
+		return upper, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(upper), 0755); err != nil {
+		return "", err
+	}
+	info, err := os.Lstat(lowerPath)
+	if err != nil {
+		// Nothing to copy up, e.g. a brand new file: the caller will
+		// create it directly in the upper layer.
+		return upper, nil
+	}
+	if info.IsDir() {
+		if err := os.Mkdir(upper, info.Mode()); err != nil && !os.IsExist(err) {
+			return "", err
+		}
+	} else {
+		if err := copyFileContents(lowerPath, upper, info.Mode()); err != nil {
+			return "", err
+		}
+	}
+	os.Remove(filepath.Join(filepath.Dir(upper), whiteoutPrefix+filepath.Base(upper)))
+	return upper, nil
+}
+
+func copyFileContents(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// cowRemove implements Remove semantics for --cow: delete the upper copy
+// if any, and if the entry is still visible in the lower layer, leave a
+// whiteout behind instead of touching the (immutable) lower layer.
+func cowRemove(lowerPath string, fs *ClueFS) error {
+	upper := upperPath(lowerPath, fs)
+	upperErr := os.Remove(upper)
+	_, lowerErr := os.Lstat(lowerPath)
+	if lowerErr == nil {
+		dir, name := filepath.Split(upper)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		wh, err := os.Create(filepath.Join(dir, whiteoutPrefix+name))
+		if err != nil {
+			return err
+		}
+		return wh.Close()
+	}
+	return upperErr
+}
+
+// cowMkdir creates dir in the upper layer, clearing any whiteout that
+// previously hid a lower-layer entry of the same name.
+func cowMkdir(lowerPath string, mode os.FileMode, fs *ClueFS) error {
+	upper := upperPath(lowerPath, fs)
+	if err := os.MkdirAll(filepath.Dir(upper), 0755); err != nil {
+		return err
+	}
+	if err := os.Mkdir(upper, mode); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(filepath.Dir(upper), whiteoutPrefix+filepath.Base(upper)))
+	return nil
+}
+
+// cowReadDirNames merges lower and upper directory listings: upper
+// entries win over same-named lower entries, whiteouts hide lower
+// entries, and whiteout marker files themselves are never listed.
+func cowReadDirNames(lowerPath string, fs *ClueFS) ([]string, error) {
+	upper := upperPath(lowerPath, fs)
+	seen := make(map[string]bool)
+	result := make([]string, 0)
+
+	if f, err := os.Open(upper); err == nil {
+		names, _ := f.Readdirnames(0)
+		f.Close()
+		for _, n := range names {
+			if strings.HasPrefix(n, whiteoutPrefix) {
+				seen[strings.TrimPrefix(n, whiteoutPrefix)] = true
+				continue
+			}
+			seen[n] = true
+			result = append(result, n)
+		}
+	}
+
+	if f, err := os.Open(lowerPath); err == nil {
+		names, _ := f.Readdirnames(0)
+		f.Close()
+		for _, n := range names {
+			if seen[n] {
+				continue
+			}
+			result = append(result, n)
+		}
+	}
+	return result, nil
+}
@@ -19,9 +19,10 @@ var skipDirEntry func(n string) bool
 func init() {
 	switch runtime.GOOS {
 	case "darwin":
-		// On Darwin we skip all directory entries starting by '._'
+		// On Darwin we skip all directory entries starting by '._',
+		// unless --show-apple-metadata opts back into seeing them.
 		skipDirEntry = func(n string) bool {
-			return strings.HasPrefix(n, "._")
+			return !*showAppleMetadata && strings.HasPrefix(n, "._")
 		}
 	default:
 		skipDirEntry = func(n string) bool {
@@ -53,10 +54,17 @@ func NewDir(parent string, name string, fs *ClueFS) *Dir {
 
 func (d *Dir) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fusefs.Handle, error) {
 	defer trace(NewOpenOp(req, d.path))
+	if err := checkPolicy("open", d.path, req.Header.Uid); err != nil {
+		return nil, err
+	}
+	openPath := d.path
+	if cowEnabled() && upperExists(d.path, d.fs) {
+		openPath = upperPath(d.path, d.fs)
+	}
 	perm := os.FileMode(req.Flags).Perm()
 	flags := int(req.Flags)
 	newdir := NewDir(d.parent, d.name, d.fs)
-	if err := newdir.doOpen(d.path, flags, perm); err != nil {
+	if err := newdir.doOpen(openPath, flags, perm); err != nil {
 		return nil, err
 	}
 	newdir.SetProcessInfo(req.Header)
@@ -79,11 +87,42 @@ func (d *Dir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.Lo
 	if skipDirEntry(req.Name) {
 		return nil, fuse.ENOENT
 	}
-	path := filepath.Join(d.path, req.Name)
+	path, err := securePath(d.fs.shadowDir, d.path, req.Name)
+	if err != nil {
+		return nil, osErrorToFuseError(err)
+	}
 	isDir := false
 	defer trace(NewLookupOp(req, path, isDir))
+	if err := checkPolicy("lookup", path, req.Header.Uid); err != nil {
+		return nil, err
+	}
+	lookupPath := path
+	if cowEnabled() {
+		resolved, err := cowEffectivePath(path, d.fs)
+		if err != nil {
+			return nil, fuse.ENOENT
+		}
+		lookupPath = resolved
+	}
+	if *linksMode {
+		if isClueFSLinkName(req.Name) {
+			file, attr, err := lookupClueFSLink(d.path, req.Name, d.fs)
+			if err != nil {
+				return nil, err
+			}
+			resp.Attr = attr
+			resp.Node = fuse.NodeID(attr.Inode)
+			resp.AttrValid = time.Duration(1) * time.Second
+			resp.EntryValid = time.Duration(500) * time.Millisecond
+			return file, nil
+		}
+		if isShadowSymlink(lookupPath) {
+			// Real symlinks are only visible under their .cluefslink name.
+			return nil, fuse.ENOENT
+		}
+	}
 	var st syscall.Stat_t
-	if err := syscall.Lstat(path, &st); err != nil {
+	if err := syscall.Lstat(lookupPath, &st); err != nil {
 		return nil, fuse.ENOENT
 	}
 	resp.Attr = statToFuseAttr(st)
@@ -101,7 +140,13 @@ func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 		return nil, fuse.ENOTSUP
 	}
 	defer trace(NewReadDirOp(d.path, d.ProcessInfo))
-	names, err := d.file.Readdirnames(0)
+	var names []string
+	var err error
+	if cowEnabled() {
+		names, err = cowReadDirNames(d.path, d.fs)
+	} else {
+		names, err = d.file.Readdirnames(0)
+	}
 	if err != nil {
 		return nil, fuse.EIO
 	}
@@ -110,7 +155,11 @@ func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 		if skipDirEntry(n) {
 			continue
 		}
-		entry := getFuseDirent(filepath.Join(d.path, n), n)
+		entryPath := filepath.Join(d.path, n)
+		if *linksMode && isShadowSymlink(entryPath) {
+			n = n + cluefsLinkSuffix
+		}
+		entry := getFuseDirent(entryPath, n)
 		result = append(result, entry)
 	}
 
@@ -127,8 +176,20 @@ func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 }
 
 func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fusefs.Node, error) {
-	path := filepath.Join(d.path, req.Name)
+	path, err := securePath(d.fs.shadowDir, d.path, req.Name)
+	if err != nil {
+		return nil, osErrorToFuseError(err)
+	}
 	defer trace(NewMkdirOp(req, path, req.Mode))
+	if err := checkPolicy("mkdir", path, req.Header.Uid); err != nil {
+		return nil, err
+	}
+	if cowEnabled() {
+		if err := cowMkdir(path, req.Mode, d.fs); err != nil {
+			return nil, osErrorToFuseError(err)
+		}
+		return NewDir(d.path, req.Name, d.fs), nil
+	}
 	if err := os.Mkdir(path, req.Mode); err != nil {
 		return nil, osErrorToFuseError(err)
 	}
@@ -136,8 +197,20 @@ func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fusefs.Node, e
 }
 
 func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
-	path := filepath.Join(d.path, req.Name)
+	path, err := securePath(d.fs.shadowDir, d.path, req.Name)
+	if err != nil {
+		return osErrorToFuseError(err)
+	}
 	defer trace(NewRemoveOp(req, path))
+	if err := checkPolicy("remove", path, req.Header.Uid); err != nil {
+		return err
+	}
+	if cowEnabled() {
+		if err := cowRemove(path, d.fs); err != nil {
+			return osErrorToFuseError(err)
+		}
+		return nil
+	}
 	if err := os.Remove(path); err != nil {
 		return osErrorToFuseError(err)
 	}
@@ -145,9 +218,27 @@ func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
 }
 
 func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fusefs.Node, fusefs.Handle, error) {
-	path := filepath.Join(d.path, req.Name)
+	path, err := securePath(d.fs.shadowDir, d.path, req.Name)
+	if err != nil {
+		return nil, nil, osErrorToFuseError(err)
+	}
 	defer trace(NewCreateOp(req, path))
-	f, err := os.OpenFile(path, int(req.Flags), req.Mode)
+	if err := checkPolicy("create", path, req.Header.Uid); err != nil {
+		return nil, nil, err
+	}
+	if *linksMode && isClueFSLinkName(req.Name) {
+		return createClueFSLink(d.path, req.Name, d.fs)
+	}
+	createPath := path
+	if cowEnabled() {
+		upper := upperPath(path, d.fs)
+		if err := os.MkdirAll(filepath.Dir(upper), 0755); err != nil {
+			return nil, nil, osErrorToFuseError(err)
+		}
+		os.Remove(whiteoutPath(path, d.fs))
+		createPath = upper
+	}
+	f, err := os.OpenFile(createPath, int(req.Flags), req.Mode)
 	if err != nil {
 		return nil, nil, osErrorToFuseError(err)
 	}
@@ -156,9 +247,15 @@ func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.Cr
 }
 
 func (d *Dir) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fusefs.Node, error) {
-	absNewName := filepath.Join(d.path, req.NewName)
+	absNewName, err := securePath(d.fs.shadowDir, d.path, req.NewName)
+	if err != nil {
+		return nil, osErrorToFuseError(err)
+	}
 	targetIsDir := false
 	defer trace(NewSymlinkOp(req, absNewName, req.Target, targetIsDir))
+	if err := checkPolicy("symlink", absNewName, req.Header.Uid); err != nil {
+		return nil, err
+	}
 
 	// Make sure the target of the symbolic link we will create is kept
 	// within the boundaries of the shadow file system. This is necessary
@@ -177,14 +274,54 @@ func (d *Dir) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fusefs.Nod
 		linkTarget = absTarget
 	}
 
+	// With --chroot-shadow, any target that still resolves outside of
+	// shadowDir (a relative "../../etc/passwd", or an absolute path
+	// outside both mountDir and shadowDir) is re-rooted at shadowDir via
+	// the same secure resolver used for lookups, instead of being
+	// allowed to point wherever it likes. The boundary check uses
+	// shadowDir+separator so a sibling directory that merely shares
+	// shadowDir as a string prefix (e.g. "/srv/shadow-evil") isn't
+	// mistaken for being inside it.
+	shadowBoundary := d.fs.shadowDir + string(filepath.Separator)
+	cleanTarget := filepath.Clean(absTarget)
+	if *chrootShadow && cleanTarget != d.fs.shadowDir && !strings.HasPrefix(cleanTarget, shadowBoundary) {
+		rel := req.Target
+		if filepath.IsAbs(rel) {
+			rel = strings.TrimPrefix(rel, string(filepath.Separator))
+		} else {
+			relDir, err := filepath.Rel(d.fs.shadowDir, d.path)
+			if err != nil {
+				return nil, osErrorToFuseError(err)
+			}
+			rel = filepath.Join(relDir, rel)
+		}
+		resolved, err := secureJoin(d.fs.shadowDir, rel)
+		if err != nil {
+			return nil, osErrorToFuseError(err)
+		}
+		absTarget = resolved
+		linkTarget = absTarget
+	}
+
 	// Does the link target actually exist?
 	if info, err := os.Lstat(absTarget); err == nil {
 		// The symbolic link target does exist
 		targetIsDir = info.IsDir()
 	}
 
-	// Create the symbolic link: absNewName --> linkTarget
-	if err := os.Symlink(linkTarget, absNewName); err != nil {
+	// Create the symbolic link: absNewName --> linkTarget. Under --cow,
+	// shadowDir is an immutable lower layer, so the link itself has to be
+	// created in the upper directory, same as Create/Mkdir.
+	newLinkPath := absNewName
+	if cowEnabled() {
+		upper := upperPath(absNewName, d.fs)
+		if err := os.MkdirAll(filepath.Dir(upper), 0755); err != nil {
+			return nil, osErrorToFuseError(err)
+		}
+		os.Remove(whiteoutPath(absNewName, d.fs))
+		newLinkPath = upper
+	}
+	if err := os.Symlink(linkTarget, newLinkPath); err != nil {
 		return nil, osErrorToFuseError(err)
 	}
 	if targetIsDir {
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+type File struct {
+	*Node
+	*Handle
+	ProcessInfo
+
+	// link is set when this File represents a symlink in the shadow tree
+	// exposed through the mount as a "<name>.cluefslink" regular file (see
+	// --links). It holds the real (non-suffixed) shadow path of the link.
+	link string
+}
+
+func (f File) String() string {
+	return fmt.Sprintf("[%s %s %s]", f.Node, f.Handle, f.ProcessInfo)
+}
+
+func (f *File) SetProcessInfo(h fuse.Header) {
+	f.ProcessInfo = ProcessInfo{Uid: h.Uid, Gid: h.Gid, Pid: h.Pid}
+}
+
+func NewFile(parent string, name string, fs *ClueFS) *File {
+	return &File{
+		Node:   NewNode(parent, name, fs),
+		Handle: &Handle{},
+	}
+}
+
+func NewOpenFile(parent string, name string, fs *ClueFS, osFile *os.File) *File {
+	f := NewFile(parent, name, fs)
+	f.file = osFile
+	return f
+}
+
+func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fusefs.Handle, error) {
+	defer trace(NewOpenOp(req, f.path))
+	if err := checkPolicy("open", f.path, req.Header.Uid); err != nil {
+		return nil, err
+	}
+	flags := int(req.Flags)
+	openPath := f.path
+	if f.link != "" {
+		// This is a "<name>.cluefslink" node: serve the symlink's target
+		// text rather than opening f.path, which is the suffixed name and
+		// doesn't exist on the shadow disk.
+		staged, err := stageClueFSLinkRead(f.parent, f.link)
+		if err != nil {
+			return nil, osErrorToFuseError(err)
+		}
+		defer os.Remove(staged)
+		openPath = staged
+	} else if cowEnabled() {
+		if flags&(os.O_WRONLY|os.O_RDWR|os.O_TRUNC) != 0 {
+			upper, err := copyUp(f.path, f.fs)
+			if err != nil {
+				return nil, osErrorToFuseError(err)
+			}
+			openPath = upper
+		} else if upperExists(f.path, f.fs) {
+			openPath = upperPath(f.path, f.fs)
+		}
+	}
+	newfile := NewFile(f.parent, f.name, f.fs)
+	if err := newfile.doOpen(openPath, flags, 0); err != nil {
+		return nil, err
+	}
+	newfile.SetProcessInfo(req.Header)
+	resp.Handle = newfile.handleID
+	return newfile, nil
+}
+
+func (f *File) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	if !f.isOpen() {
+		return nil
+	}
+	defer trace(NewReleaseOp(req, f.path))
+	if req.ReleaseFlags&fuse.ReleaseFlush != 0 {
+		f.doSync()
+	}
+	if err := f.doClose(); err != nil {
+		return err
+	}
+	if f.link != "" {
+		return f.finishClueFSLink()
+	}
+	return nil
+}
+
+func (f *File) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	if err := checkPolicy("read", f.path, f.ProcessInfo.Uid); err != nil {
+		return err
+	}
+	op := NewReadOp(f.path, f.ProcessInfo)
+	defer trace(op)
+	buf := make([]byte, req.Size)
+	n, err := f.file.ReadAt(buf, req.Offset)
+	op.Size = int64(n)
+	if err != nil && n == 0 {
+		return osErrorToFuseError(err)
+	}
+	resp.Data = buf[:n]
+	return nil
+}
+
+func (f *File) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	if err := checkPolicy("write", f.path, f.ProcessInfo.Uid); err != nil {
+		return err
+	}
+	op := NewWriteOp(f.path, f.ProcessInfo)
+	defer trace(op)
+	n, err := f.file.WriteAt(req.Data, req.Offset)
+	op.Size = int64(n)
+	if err != nil {
+		return osErrorToFuseError(err)
+	}
+	resp.Size = n
+	return nil
+}
+
+func (f *File) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	return nil
+}
+
+func (f *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	defer trace(newOp("Fsync", f.path))
+	return f.doSync()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+)
+
+// linksMode exposes symlinks in the shadow tree as regular files suffixed
+// with cluefsLinkSuffix, so tools that cannot traverse symlinks (backup
+// tools, archivers, WebDAV clients) can still see and manipulate link
+// targets through the mount. Creating a "<name>.cluefslink" file through
+// the mount is translated into an os.Symlink call on the shadow.
+var linksMode = flag.Bool("links", false, "expose shadow symlinks as *.cluefslink regular files")
+
+const cluefsLinkSuffix = ".cluefslink"
+
+func isClueFSLinkName(name string) bool {
+	return strings.HasSuffix(name, cluefsLinkSuffix)
+}
+
+func clueFSLinkRealName(name string) string {
+	return strings.TrimSuffix(name, cluefsLinkSuffix)
+}
+
+// isShadowSymlink reports whether path, as it exists in the shadow tree,
+// is itself a symlink (as opposed to a regular file or directory).
+func isShadowSymlink(path string) bool {
+	var st syscall.Stat_t
+	if err := syscall.Lstat(path, &st); err != nil {
+		return false
+	}
+	return st.Mode&syscall.S_IFMT == syscall.S_IFLNK
+}
+
+// lookupClueFSLink resolves a "<name>.cluefslink" lookup to the symlink it
+// stands for, reporting its target length as the apparent file size.
+func lookupClueFSLink(dirPath, name string, fs *ClueFS) (*File, fuse.Attr, error) {
+	realPath := filepath.Join(dirPath, clueFSLinkRealName(name))
+	var st syscall.Stat_t
+	if err := syscall.Lstat(realPath, &st); err != nil {
+		return nil, fuse.Attr{}, fuse.ENOENT
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFLNK {
+		return nil, fuse.Attr{}, fuse.ENOENT
+	}
+	target, err := os.Readlink(realPath)
+	if err != nil {
+		return nil, fuse.Attr{}, osErrorToFuseError(err)
+	}
+	attr := statToFuseAttr(st)
+	attr.Mode = os.FileMode(0444)
+	attr.Size = uint64(len(target))
+	file := NewFile(dirPath, name, fs)
+	file.link = realPath
+	return file, attr, nil
+}
+
+// createClueFSLink stages a writable handle: the bytes later written to
+// it become the target of an os.Symlink created against realPath once the
+// handle is released.
+func createClueFSLink(dirPath, name string, fs *ClueFS) (fusefs.Node, fusefs.Handle, error) {
+	realPath := filepath.Join(dirPath, clueFSLinkRealName(name))
+	tmp, err := ioutil.TempFile(dirPath, ".cluefslink-tmp-")
+	if err != nil {
+		return nil, nil, osErrorToFuseError(err)
+	}
+	file := NewOpenFile(dirPath, name, fs, tmp)
+	file.link = realPath
+	return file, file, nil
+}
+
+// stageClueFSLinkRead copies link's target text into a fresh temporary
+// file under dir, so File.Open can serve a read of "<name>.cluefslink"
+// like any other open file instead of reading the symlink itself (which,
+// reported as a 0444 regular file, the kernel will never ask for via
+// FUSE_READLINK). The caller is responsible for removing the returned
+// path once it has been opened.
+func stageClueFSLinkRead(dir, link string) (string, error) {
+	target, err := os.Readlink(link)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(dir, ".cluefslink-read-")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+	if _, err := tmp.WriteString(target); err != nil {
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// finishClueFSLink turns a staged .cluefslink write into a real symlink
+// and discards the temporary staging file. Called from File.Release.
+func (f *File) finishClueFSLink() error {
+	tmpPath := f.file.Name()
+	defer os.Remove(tmpPath)
+	content, err := ioutil.ReadFile(tmpPath)
+	if err != nil {
+		return osErrorToFuseError(err)
+	}
+	target := string(bytes.TrimRight(content, "\n"))
+	os.Remove(f.link)
+	if err := os.Symlink(target, f.link); err != nil {
+		return osErrorToFuseError(err)
+	}
+	return nil
+}
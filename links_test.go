@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bazil.org/fuse"
+)
+
+func TestLookupClueFSLink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cluefs-links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	realPath := filepath.Join(dir, "foo")
+	if err := os.Symlink("/etc/passwd", realPath); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &ClueFS{shadowDir: dir}
+	file, attr, err := lookupClueFSLink(dir, "foo.cluefslink", fs)
+	if err != nil {
+		t.Fatalf("lookupClueFSLink: %v", err)
+	}
+	if file.link != realPath {
+		t.Fatalf("file.link = %q, want %q", file.link, realPath)
+	}
+	if attr.Mode&os.ModeSymlink != 0 {
+		t.Fatalf("attr.Mode = %v, want a plain regular-file mode with no symlink bit", attr.Mode)
+	}
+	if attr.Size != uint64(len("/etc/passwd")) {
+		t.Fatalf("attr.Size = %d, want %d", attr.Size, len("/etc/passwd"))
+	}
+
+	if _, _, err := lookupClueFSLink(dir, "missing.cluefslink", fs); err != fuse.ENOENT {
+		t.Fatalf("lookupClueFSLink(missing) = %v, want ENOENT", err)
+	}
+}
+
+func TestStageClueFSLinkRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cluefs-links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	realPath := filepath.Join(dir, "foo")
+	if err := os.Symlink("some/target", realPath); err != nil {
+		t.Fatal(err)
+	}
+
+	staged, err := stageClueFSLinkRead(dir, realPath)
+	if err != nil {
+		t.Fatalf("stageClueFSLinkRead: %v", err)
+	}
+	defer os.Remove(staged)
+
+	content, err := ioutil.ReadFile(staged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "some/target" {
+		t.Fatalf("staged content = %q, want %q", content, "some/target")
+	}
+}
+
+func TestCreateAndFinishClueFSLink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cluefs-links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := &ClueFS{shadowDir: dir}
+	node, handle, err := createClueFSLink(dir, "foo.cluefslink", fs)
+	if err != nil {
+		t.Fatalf("createClueFSLink: %v", err)
+	}
+	file := node.(*File)
+	if file != handle.(*File) {
+		t.Fatalf("createClueFSLink returned different node/handle values")
+	}
+	if _, err := file.file.WriteString("/etc/shadow\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.finishClueFSLink(); err != nil {
+		t.Fatalf("finishClueFSLink: %v", err)
+	}
+
+	target, err := os.Readlink(filepath.Join(dir, "foo"))
+	if err != nil {
+		t.Fatalf("Readlink: %v", err)
+	}
+	if target != "/etc/shadow" {
+		t.Fatalf("symlink target = %q, want %q", target, "/etc/shadow")
+	}
+}
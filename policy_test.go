@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+)
+
+func TestGlobToRegexpRecursive(t *testing.T) {
+	re, err := globToRegexp("/etc/**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/etc/passwd", "/etc/sub/passwd", "/etc/a/b/c"} {
+		if !re.MatchString(p) {
+			t.Errorf("pattern /etc/** should match %q", p)
+		}
+	}
+	if re.MatchString("/other/passwd") {
+		t.Errorf("pattern /etc/** should not match /other/passwd")
+	}
+}
+
+// TestCheckPolicyEndToEnd drives checkPolicy through a real --policy rule
+// file, the same path Dir/File entry points use, to make sure a deny
+// rule actually takes effect (activePolicy was previously never wired up
+// past its allowAllPolicy zero value, so this would have failed).
+func TestCheckPolicyEndToEnd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cluefs-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rulesPath := filepath.Join(dir, "rules.json")
+	rules := `[{"path":"/etc/**","ops":["write"],"errno":"EACCES"}]`
+	if err := ioutil.WriteFile(rulesPath, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	policyOnce = sync.Once{}
+	activePolicy = allowAllPolicy{}
+	*chaosFlag = false
+	*policyFile = rulesPath
+	defer func() { *policyFile = "" }()
+
+	if err := checkPolicy("write", "/etc/sub/passwd", 1000); err != fuse.Errno(syscall.EACCES) {
+		t.Fatalf("checkPolicy(write, /etc/sub/passwd) = %v, want EACCES", err)
+	}
+	if err := checkPolicy("read", "/etc/sub/passwd", 1000); err != nil {
+		t.Fatalf("checkPolicy(read, ...) = %v, want nil (rule only covers write)", err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// chrootShadow enables secure path resolution: every path built from a
+// shadow-relative name is resolved component-by-component so that a
+// symlink placed inside the shadow tree cannot be used to make cluefs
+// read or mutate files outside of shadowDir (a confused-deputy hole,
+// since plain filepath.Join + Lstat happily follows such a symlink).
+var chrootShadow = flag.Bool("chroot-shadow", false, "resolve paths securely so shadow-tree symlinks cannot escape shadowDir")
+
+// maxSymlinkIterations bounds the number of symlinks secureJoinDir will
+// follow while resolving a single path, the same way the kernel bounds
+// MAXSYMLINKS, so a symlink loop in the shadow tree returns ELOOP
+// instead of looping forever.
+const maxSymlinkIterations = 40
+
+// secureJoinDir resolves relDir, a path made up only of directory
+// components, against root the way cyphar/filepath-securejoin does: it
+// walks the path component by component and, for each component that
+// turns out to be a symlink, re-roots absolute targets at root and
+// splices relative targets back into the remaining path. The result
+// (root-relative, always starting with a separator) is always inside
+// root, even when the shadow tree contains symlinks (possibly placed
+// there maliciously) pointing outside of it.
+func secureJoinDir(root, relDir string) (string, error) {
+	resolved := string(filepath.Separator)
+	remaining := relDir
+	iterations := 0
+
+	for remaining != "" {
+		var component string
+		if i := strings.IndexByte(remaining, filepath.Separator); i >= 0 {
+			component, remaining = remaining[:i], remaining[i+1:]
+		} else {
+			component, remaining = remaining, ""
+		}
+
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		candidate := filepath.Join(resolved, component)
+		fullCandidate := filepath.Join(root, candidate)
+
+		fi, err := os.Lstat(fullCandidate)
+		if err != nil {
+			// Doesn't exist yet: nothing to resolve, just append it.
+			resolved = candidate
+			continue
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = candidate
+			continue
+		}
+
+		iterations++
+		if iterations > maxSymlinkIterations {
+			return "", syscall.ELOOP
+		}
+		target, err := os.Readlink(fullCandidate)
+		if err != nil {
+			return "", err
+		}
+		sep := string(filepath.Separator)
+		if filepath.IsAbs(target) {
+			remaining = strings.TrimPrefix(target, sep) + sep + remaining
+			resolved = sep
+		} else {
+			remaining = target + sep + remaining
+		}
+	}
+
+	return resolved, nil
+}
+
+// secureJoin resolves relPath against root the same way secureJoinDir
+// does, except the final path component is never dereferenced: callers
+// (Lookup, Remove, Symlink, ...) need to operate on the entry itself,
+// not silently follow it if it happens to be a symlink.
+func secureJoin(root, relPath string) (string, error) {
+	dir, base := filepath.Split(relPath)
+	resolvedDir, err := secureJoinDir(root, dir)
+	if err != nil {
+		return "", err
+	}
+	if base == "" {
+		return filepath.Join(root, resolvedDir), nil
+	}
+	return filepath.Join(root, resolvedDir, base), nil
+}
+
+// securePath computes the shadow path for name inside dirPath, resolving
+// the directory components securely against root when --chroot-shadow is
+// set (name itself is never dereferenced even if it is a symlink), and
+// falling back to a plain filepath.Join otherwise.
+func securePath(root, dirPath, name string) (string, error) {
+	if !*chrootShadow {
+		return filepath.Join(dirPath, name), nil
+	}
+	rel, err := filepath.Rel(root, dirPath)
+	if err != nil {
+		return "", err
+	}
+	return secureJoin(root, filepath.Join(rel, name))
+}
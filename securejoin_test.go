@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecureJoin(t *testing.T) {
+	root, err := ioutil.TempDir("", "cluefs-securejoin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "sub", "file"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc/passwd", filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../../etc", filepath.Join(root, "sub", "relescape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("loop2", filepath.Join(root, "loop1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("loop1", filepath.Join(root, "loop2")); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		relPath string
+		want    string
+		wantErr bool
+	}{
+		{"plain path is untouched", "sub/file", filepath.Join(root, "sub", "file"), false},
+		{"leading .. is clamped at root", "sub/../../../etc/passwd", filepath.Join(root, "etc", "passwd"), false},
+		{"final symlink component is not dereferenced", "escape", filepath.Join(root, "escape"), false},
+		{"intermediate relative-target symlink is clamped at root", "sub/relescape/passwd", filepath.Join(root, "etc", "passwd"), false},
+		{"symlink loop as an intermediate component returns ELOOP", "loop1/x", "", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := secureJoin(root, c.relPath)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("secureJoin(%q) = %q, want an error", c.relPath, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("secureJoin(%q) unexpected error: %v", c.relPath, err)
+			}
+			if got != c.want {
+				t.Fatalf("secureJoin(%q) = %q, want %q", c.relPath, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"runtime"
+	"strings"
+
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+)
+
+// showAppleMetadata opts in to exposing the com.apple.* xattr namespace
+// and the "._" AppleDouble sidecar files that skipDirEntry otherwise
+// blanket-hides on Darwin.
+var showAppleMetadata = flag.Bool("show-apple-metadata", false, "on Darwin, expose com.apple.* xattrs and ._ AppleDouble entries")
+
+// The four xattr syscalls differ enough between Linux and Darwin (value
+// layout, the Darwin "position" argument used for resource forks) that
+// each platform gets its own implementation, selected the same way
+// skipDirEntry already picks a GOOS-specific implementation in dir.go.
+var (
+	sysGetxattr    func(path, name string) ([]byte, error)
+	sysSetxattr    func(path, name string, value []byte, flags int) error
+	sysListxattr   func(path string) ([]string, error)
+	sysRemovexattr func(path, name string) error
+)
+
+func init() {
+	switch runtime.GOOS {
+	case "darwin":
+		sysGetxattr = darwinGetxattr
+		sysSetxattr = darwinSetxattr
+		sysListxattr = darwinListxattr
+		sysRemovexattr = darwinRemovexattr
+	default:
+		sysGetxattr = linuxGetxattr
+		sysSetxattr = linuxSetxattr
+		sysListxattr = linuxListxattr
+		sysRemovexattr = linuxRemovexattr
+	}
+}
+
+func skipXattr(name string) bool {
+	return !*showAppleMetadata && strings.HasPrefix(name, "com.apple.")
+}
+
+func doGetxattr(path string, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	defer trace(NewGetxattrOp(req, path))
+	if skipXattr(req.Name) {
+		return fuse.ErrNoXattr
+	}
+	value, err := sysGetxattr(path, req.Name)
+	if err != nil {
+		return osErrorToFuseError(err)
+	}
+	resp.Xattr = value
+	return nil
+}
+
+func doSetxattr(path string, req *fuse.SetxattrRequest) error {
+	defer trace(NewSetxattrOp(req, path))
+	if err := sysSetxattr(path, req.Name, req.Xattr, int(req.Flags)); err != nil {
+		return osErrorToFuseError(err)
+	}
+	return nil
+}
+
+func doListxattr(path string, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	defer trace(NewListxattrOp(req, path))
+	names, err := sysListxattr(path)
+	if err != nil {
+		return osErrorToFuseError(err)
+	}
+	for _, name := range names {
+		if skipXattr(name) {
+			continue
+		}
+		resp.Append(name)
+	}
+	return nil
+}
+
+func doRemovexattr(path string, req *fuse.RemovexattrRequest) error {
+	defer trace(NewRemovexattrOp(req, path))
+	if err := sysRemovexattr(path, req.Name); err != nil {
+		return osErrorToFuseError(err)
+	}
+	return nil
+}
+
+func (d *Dir) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	return doGetxattr(d.path, req, resp)
+}
+
+func (d *Dir) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	return doSetxattr(d.path, req)
+}
+
+func (d *Dir) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	return doListxattr(d.path, req, resp)
+}
+
+func (d *Dir) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	return doRemovexattr(d.path, req)
+}
+
+// Fsyncdir mirrors the existing doSync handler used from File/Dir
+// Release, applied directly to the open directory handle.
+func (d *Dir) Fsyncdir(ctx context.Context, req *fuse.FsyncRequest) error {
+	defer trace(NewFsyncdirOp(req, d.path))
+	return d.doSync()
+}
+
+func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	return doGetxattr(f.path, req, resp)
+}
+
+func (f *File) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	return doSetxattr(f.path, req)
+}
+
+func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	return doListxattr(f.path, req, resp)
+}
+
+func (f *File) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	return doRemovexattr(f.path, req)
+}
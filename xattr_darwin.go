@@ -0,0 +1,52 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// Note: unix.Getxattr/Setxattr don't expose Darwin's extra xattr
+// "position" argument (used by the OS for the resource-fork portion of
+// com.apple.ResourceFork), so that attribute isn't handled specially
+// here; it round-trips like any other xattr, at position 0.
+
+func darwinGetxattr(path, name string) ([]byte, error) {
+	size, err := unix.Getxattr(path, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	n, err := unix.Getxattr(path, name, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func darwinSetxattr(path, name string, value []byte, flags int) error {
+	return unix.Setxattr(path, name, value, flags)
+}
+
+func darwinListxattr(path string) ([]string, error) {
+	size, err := unix.Listxattr(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	n, err := unix.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	start := 0
+	for i, b := range buf[:n] {
+		if b == 0 {
+			if i > start {
+				names = append(names, string(buf[start:i]))
+			}
+			start = i + 1
+		}
+	}
+	return names, nil
+}
+
+func darwinRemovexattr(path, name string) error {
+	return unix.Removexattr(path, name)
+}
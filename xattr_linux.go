@@ -0,0 +1,57 @@
+package main
+
+import "syscall"
+
+func linuxGetxattr(path, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	n, err := syscall.Getxattr(path, name, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func linuxSetxattr(path, name string, value []byte, flags int) error {
+	return syscall.Setxattr(path, name, value, flags)
+}
+
+func linuxListxattr(path string) ([]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	n, err := syscall.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range splitXattrNames(buf[:n]) {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func linuxRemovexattr(path, name string) error {
+	return syscall.Removexattr(path, name)
+}
+
+// splitXattrNames splits the NUL-separated name list returned by
+// listxattr(2) into individual names.
+func splitXattrNames(buf []byte) []string {
+	var names []string
+	start := 0
+	for i, b := range buf {
+		if b == 0 {
+			if i > start {
+				names = append(names, string(buf[start:i]))
+			}
+			start = i + 1
+		}
+	}
+	return names
+}